@@ -0,0 +1,88 @@
+package messaging
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// reservedFields are the top-level keys LogstashFormatter owns; any
+// entry.Data field that collides with one of them is renamed to
+// "fields.<key>" instead of being dropped. ECSFormatter doesn't use them,
+// since it nests every entry.Data field under "labels".
+var reservedFields = map[string]bool{
+	"@timestamp": true,
+	"@version":   true,
+	"message":    true,
+	"level":      true,
+	"type":       true,
+}
+
+// LogstashFormatter formats logrus entries as Logstash-compatible JSON
+// events: "@timestamp", "@version", "message", "level", "type", plus the
+// entry's fields flattened at the top level.
+type LogstashFormatter struct {
+	// Type is reported under the "type" key on every event.
+	Type string
+}
+
+// NewLogstashFormatter creates a LogstashFormatter that tags every event
+// with the given app/type value.
+func NewLogstashFormatter(appType string) *LogstashFormatter {
+	return &LogstashFormatter{Type: appType}
+}
+
+// Format implements logrus.Formatter.
+func (f *LogstashFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	fields := make(logrus.Fields, len(entry.Data)+5)
+
+	for k, v := range entry.Data {
+		if reservedFields[k] {
+			fields["fields."+k] = v
+		} else {
+			fields[k] = v
+		}
+	}
+
+	fields["@timestamp"] = entry.Time.Format(time.RFC3339Nano)
+	fields["@version"] = "1"
+	fields["message"] = entry.Message
+	fields["level"] = entry.Level.String()
+	fields["type"] = f.Type
+
+	return json.Marshal(fields)
+}
+
+// ECSFormatter formats logrus entries following the Elastic Common Schema:
+// "message" and "@timestamp" stay top-level, severity moves to
+// "log.level", and custom fields are nested under "labels.*".
+type ECSFormatter struct {
+	// Type is reported under the "type" key on every event.
+	Type string
+}
+
+// NewECSFormatter creates an ECSFormatter that tags every event with the
+// given app/type value.
+func NewECSFormatter(appType string) *ECSFormatter {
+	return &ECSFormatter{Type: appType}
+}
+
+// Format implements logrus.Formatter.
+func (f *ECSFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	labels := make(logrus.Fields, len(entry.Data))
+	for k, v := range entry.Data {
+		labels[k] = v
+	}
+
+	fields := logrus.Fields{
+		"@timestamp": entry.Time.Format(time.RFC3339Nano),
+		"@version":   "1",
+		"message":    entry.Message,
+		"log.level":  entry.Level.String(),
+		"type":       f.Type,
+		"labels":     labels,
+	}
+
+	return json.Marshal(fields)
+}
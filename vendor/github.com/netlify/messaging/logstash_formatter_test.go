@@ -0,0 +1,81 @@
+package messaging
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+func TestLogstashFormatterReservedKeyCollision(t *testing.T) {
+	entry := &logrus.Entry{
+		Time:    time.Now(),
+		Level:   logrus.InfoLevel,
+		Message: "hello",
+		Data: logrus.Fields{
+			"level": "not-a-level",
+			"user":  "ren",
+		},
+	}
+
+	b, err := NewLogstashFormatter("myapp").Format(entry)
+	if err != nil {
+		t.Fatalf("Format returned error: %v", err)
+	}
+
+	var out map[string]interface{}
+	if err := json.Unmarshal(b, &out); err != nil {
+		t.Fatalf("output isn't valid JSON: %v", err)
+	}
+
+	if out["level"] != "info" {
+		t.Errorf("level = %v, want the entry's own level (collision should rename the field)", out["level"])
+	}
+	if out["fields.level"] != "not-a-level" {
+		t.Errorf("fields.level = %v, want the renamed colliding value", out["fields.level"])
+	}
+	if out["user"] != "ren" {
+		t.Errorf("user = %v, want ren", out["user"])
+	}
+	if out["type"] != "myapp" {
+		t.Errorf("type = %v, want myapp", out["type"])
+	}
+	if out["@version"] != "1" {
+		t.Errorf("@version = %v, want 1", out["@version"])
+	}
+}
+
+func TestECSFormatterNestsFieldsUnderLabels(t *testing.T) {
+	entry := &logrus.Entry{
+		Time:    time.Now(),
+		Level:   logrus.ErrorLevel,
+		Message: "boom",
+		Data:    logrus.Fields{"request_id": "abc123"},
+	}
+
+	b, err := NewECSFormatter("myapp").Format(entry)
+	if err != nil {
+		t.Fatalf("Format returned error: %v", err)
+	}
+
+	var out map[string]interface{}
+	if err := json.Unmarshal(b, &out); err != nil {
+		t.Fatalf("output isn't valid JSON: %v", err)
+	}
+
+	if out["log.level"] != "error" {
+		t.Errorf("log.level = %v, want error", out["log.level"])
+	}
+	if out["message"] != "boom" {
+		t.Errorf("message = %v, want boom", out["message"])
+	}
+
+	labels, ok := out["labels"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("labels = %v, want a nested object", out["labels"])
+	}
+	if labels["request_id"] != "abc123" {
+		t.Errorf("labels.request_id = %v, want abc123", labels["request_id"])
+	}
+}
@@ -1,11 +1,30 @@
 package messaging
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
 
 	"github.com/Sirupsen/logrus"
-	"github.com/nats-io/nats"
+	"github.com/nats-io/nats.go"
+)
+
+// OverflowPolicy controls what NatsHook does with an entry that can't be
+// enqueued because the async publish buffer is full.
+type OverflowPolicy string
+
+const (
+	// OverflowBlock blocks Fire until space is available in the buffer.
+	OverflowBlock OverflowPolicy = "block"
+	// OverflowDropNewest discards the entry that just failed to enqueue.
+	OverflowDropNewest OverflowPolicy = "drop_newest"
+	// OverflowDropOldest discards the oldest queued entry to make room for the new one.
+	OverflowDropOldest OverflowPolicy = "drop_oldest"
 )
 
 // HookConf defines the vars needed to connect to nats and add the logrus hook
@@ -13,17 +32,97 @@ type HookConf struct {
 	NatsConfig
 	Subject    string            `json:"subject"`
 	Dimensions map[string]string `json:"dimensions"`
+	// Format selects the wire format for published entries: "json" (default),
+	// "logstash", or "ecs".
+	Format string `json:"format"`
+	// Type is the app/type tag reported under the "type" key by the
+	// "logstash" and "ecs" formats. Ignored for "json".
+	Type string `json:"type"`
+
+	// Async, when true, makes Fire enqueue entries for background publishing
+	// instead of calling conn.Publish inline. It is off by default so the
+	// hook keeps behaving synchronously unless a caller opts in. When
+	// Reliable is also set, Fire takes the reliable path and the async
+	// queue is not used.
+	Async bool `json:"async"`
+	// BufferSize is the capacity of the async publish queue.
+	BufferSize int `json:"bufferSize"`
+	// Workers is the number of goroutines draining the async publish queue.
+	Workers int `json:"workers"`
+	// FlushInterval forces a partial batch to publish even if MaxBatch
+	// hasn't been reached yet. Zero disables the timer-based flush.
+	FlushInterval time.Duration `json:"flushInterval"`
+	// MaxBatch is the number of entries grouped into a single NATS message
+	// on the ".batch" subject. 1 (the default) publishes one message per entry.
+	MaxBatch int `json:"maxBatch"`
+	// OverflowPolicy says what to do when the async buffer is full.
+	OverflowPolicy OverflowPolicy `json:"overflowPolicy"`
+
+	// Reliable, when true, publishes through JetStream with async acks
+	// and spools to disk while disconnected, turning the hook from
+	// best-effort into at-least-once delivery.
+	Reliable      bool          `json:"reliable"`
+	Stream        string        `json:"stream"`
+	MaxPending    int           `json:"maxPending"`
+	AckWait       time.Duration `json:"ackWait"`
+	SpoolDir      string        `json:"spoolDir"`
+	SpoolMaxBytes int64         `json:"spoolMaxBytes"`
+
+	// SubjectTemplate, if set, is a text/template string evaluated per
+	// Fire against {{.Level}} and {{.Fields.<key>}} to compute the
+	// publish subject, e.g. "logs.{{.Level}}.{{.Fields.service}}".
+	SubjectTemplate string `json:"subjectTemplate"`
+	// LevelSubjects routes entries to a fixed subject per level. It's
+	// overridden by SubjectTemplate (and SubjectFunc, set via
+	// SetSubjectFunc) when those are also present.
+	LevelSubjects map[logrus.Level]string `json:"levelSubjects"`
+}
+
+// natsPublisher is the subset of *nats.Conn that NatsHook depends on. It
+// exists so tests can substitute an in-process fake instead of dialing a
+// real nats-server; see messaging/natstest.
+type natsPublisher interface {
+	Publish(subject string, data []byte) error
+	IsClosed() bool
+	IsConnected() bool
+	JetStream(opts ...nats.JSOpt) (nats.JetStreamContext, error)
 }
 
 // NatsHook will emit logs to the subject provided
 type NatsHook struct {
-	conn          *nats.Conn
+	conn          natsPublisher
 	subject       string
 	extraFields   map[string]string
 	dynamicFields map[string]func() string
 	formatter     logrus.Formatter
 
 	LogLevels []logrus.Level
+
+	async         bool
+	queue         chan queuedEntry
+	overflow      OverflowPolicy
+	maxBatch      int
+	flushInterval time.Duration
+	wg            sync.WaitGroup
+	stopCh        chan struct{}
+	closeOnce     sync.Once
+
+	published int64
+	dropped   int64
+	errs      int64
+
+	reliable    bool
+	js          nats.JetStreamContext
+	stream      string
+	maxPending  int
+	ackWait     time.Duration
+	spool       *diskSpool
+	errHandler  func(error)
+	pendingAcks int64
+
+	subjectTemplate *template.Template
+	subjectFunc     func(*logrus.Entry) string
+	levelSubjects   map[logrus.Level]string
 }
 
 // AddNatsHook will connect to nats, add the hook to logrus, and percolate any errors up
@@ -39,13 +138,54 @@ func AddNatsHook(conf *HookConf) (*nats.Conn, *NatsHook, error) {
 
 	hook, err := NewNatsHook(nc, conf.Subject)
 	if err != nil {
+		nc.Close()
 		return nil, nil, err
 	}
 
+	switch conf.Format {
+	case "", "json":
+		// leave the default JSONFormatter in place
+	case "logstash":
+		hook.SetFormatter(NewLogstashFormatter(conf.Type))
+	case "ecs":
+		hook.SetFormatter(NewECSFormatter(conf.Type))
+	default:
+		nc.Close()
+		return nil, nil, fmt.Errorf("unknown nats hook format: %s", conf.Format)
+	}
+
 	for k, v := range conf.Dimensions {
 		hook.AddField(k, v)
 	}
 
+	if conf.SubjectTemplate != "" {
+		if err := hook.SetSubjectTemplate(conf.SubjectTemplate); err != nil {
+			nc.Close()
+			return nil, nil, fmt.Errorf("invalid nats hook subject template: %v", err)
+		}
+	}
+
+	if conf.LevelSubjects != nil {
+		hook.SetLevelSubjects(conf.LevelSubjects)
+	}
+
+	if conf.Async {
+		hook.EnableAsync(conf.BufferSize, conf.Workers, conf.FlushInterval, conf.MaxBatch, conf.OverflowPolicy)
+	}
+
+	if conf.Reliable {
+		if err := hook.EnableReliable(ReliableConf{
+			Stream:        conf.Stream,
+			MaxPending:    conf.MaxPending,
+			AckWait:       conf.AckWait,
+			SpoolDir:      conf.SpoolDir,
+			SpoolMaxBytes: conf.SpoolMaxBytes,
+		}); err != nil {
+			nc.Close()
+			return nil, nil, err
+		}
+	}
+
 	logrus.AddHook(hook)
 
 	return nc, hook, nil
@@ -53,7 +193,7 @@ func AddNatsHook(conf *HookConf) (*nats.Conn, *NatsHook, error) {
 
 // NewNatsHook will create a logrus hook that will automatically send
 // new info into the channel
-func NewNatsHook(conn *nats.Conn, subject string) (*NatsHook, error) {
+func NewNatsHook(conn natsPublisher, subject string) (*NatsHook, error) {
 	hook := NatsHook{
 		conn:          conn,
 		subject:       subject,
@@ -73,6 +213,13 @@ func NewNatsHook(conn *nats.Conn, subject string) (*NatsHook, error) {
 	return &hook, nil
 }
 
+// SetFormatter overrides the logrus.Formatter used to serialize entries
+// before they are published to NATS. The default is a plain logrus.JSONFormatter.
+func (hook *NatsHook) SetFormatter(formatter logrus.Formatter) *NatsHook {
+	hook.formatter = formatter
+	return hook
+}
+
 // AddField will add a simple value each emission
 func (hook *NatsHook) AddField(key, value string) *NatsHook {
 	hook.extraFields[key] = value
@@ -85,6 +232,207 @@ func (hook *NatsHook) AddDynamicField(key string, generator func() string) *Nats
 	return hook
 }
 
+// EnableAsync switches the hook into asynchronous mode: Fire enqueues the
+// formatted entry instead of publishing it inline, and workers goroutines
+// drain the queue, grouping up to maxBatch entries (or whatever has
+// accumulated every flushInterval) into a single newline-delimited message
+// on "<subject>.batch"; a batch holding a single entry is published on
+// "<subject>" as is. policy governs what happens when the queue is full;
+// an empty policy defaults to OverflowBlock.
+func (hook *NatsHook) EnableAsync(bufferSize, workers int, flushInterval time.Duration, maxBatch int, policy OverflowPolicy) *NatsHook {
+	if bufferSize <= 0 {
+		bufferSize = 1
+	}
+	if workers <= 0 {
+		workers = 1
+	}
+	if maxBatch <= 0 {
+		maxBatch = 1
+	}
+	if policy == "" {
+		policy = OverflowBlock
+	}
+
+	hook.async = true
+	hook.queue = make(chan queuedEntry, bufferSize)
+	hook.overflow = policy
+	hook.maxBatch = maxBatch
+	hook.flushInterval = flushInterval
+	hook.stopCh = make(chan struct{})
+
+	for i := 0; i < workers; i++ {
+		hook.wg.Add(1)
+		go hook.publishLoop()
+	}
+
+	return hook
+}
+
+// Published returns the number of entries successfully handed to NATS.
+func (hook *NatsHook) Published() int64 {
+	return atomic.LoadInt64(&hook.published)
+}
+
+// Dropped returns the number of entries discarded because the async
+// buffer was full and the overflow policy wasn't OverflowBlock.
+func (hook *NatsHook) Dropped() int64 {
+	return atomic.LoadInt64(&hook.dropped)
+}
+
+// Errors returns the number of entries that failed to publish.
+func (hook *NatsHook) Errors() int64 {
+	return atomic.LoadInt64(&hook.errs)
+}
+
+// QueueDepth returns the number of formatted entries currently buffered
+// for async publishing. It is always zero in synchronous mode.
+func (hook *NatsHook) QueueDepth() int64 {
+	return int64(len(hook.queue))
+}
+
+// Close drains the async publish queue and stops its workers, returning
+// ctx.Err() if ctx is done before the drain completes. It is a no-op for
+// hooks that were never switched into async mode.
+func (hook *NatsHook) Close(ctx context.Context) error {
+	if !hook.async {
+		return nil
+	}
+
+	var err error
+	hook.closeOnce.Do(func() {
+		close(hook.stopCh)
+
+		done := make(chan struct{})
+		go func() {
+			hook.wg.Wait()
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	})
+
+	return err
+}
+
+// queuedEntry is a formatted entry waiting in the async publish queue,
+// tagged with the subject it resolved to at Fire time.
+type queuedEntry struct {
+	subject string
+	data    []byte
+}
+
+// enqueue hands a formatted entry to the async publish queue, applying
+// hook.overflow if the queue is full.
+func (hook *NatsHook) enqueue(subject string, b []byte) error {
+	item := queuedEntry{subject: subject, data: b}
+
+	switch hook.overflow {
+	case OverflowDropNewest:
+		select {
+		case hook.queue <- item:
+		default:
+			atomic.AddInt64(&hook.dropped, 1)
+		}
+	case OverflowDropOldest:
+		select {
+		case hook.queue <- item:
+		default:
+			select {
+			case <-hook.queue:
+				atomic.AddInt64(&hook.dropped, 1)
+			default:
+			}
+			select {
+			case hook.queue <- item:
+			default:
+				atomic.AddInt64(&hook.dropped, 1)
+			}
+		}
+	default:
+		hook.queue <- item
+	}
+
+	return nil
+}
+
+// publishLoop drains the queue, batching up to hook.maxBatch entries per
+// subject (or flushing early on hook.flushInterval), until told to stop.
+func (hook *NatsHook) publishLoop() {
+	defer hook.wg.Done()
+
+	batches := make(map[string][][]byte)
+	count := 0
+
+	flush := func() {
+		for subject, batch := range batches {
+			if err := hook.publishBatch(subject, batch); err != nil {
+				atomic.AddInt64(&hook.errs, int64(len(batch)))
+			} else {
+				atomic.AddInt64(&hook.published, int64(len(batch)))
+			}
+		}
+		batches = make(map[string][][]byte)
+		count = 0
+	}
+
+	add := func(item queuedEntry) {
+		batches[item.subject] = append(batches[item.subject], item.data)
+		count++
+		if count >= hook.maxBatch {
+			flush()
+		}
+	}
+
+	var tickCh <-chan time.Time
+	if hook.flushInterval > 0 {
+		ticker := time.NewTicker(hook.flushInterval)
+		defer ticker.Stop()
+		tickCh = ticker.C
+	}
+
+	for {
+		select {
+		case item := <-hook.queue:
+			add(item)
+		case <-tickCh:
+			flush()
+		case <-hook.stopCh:
+			for {
+				select {
+				case item := <-hook.queue:
+					add(item)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// publishBatch sends a single entry on subject, or frames multiple
+// entries as newline-delimited JSON on "<subject>.batch".
+func (hook *NatsHook) publishBatch(subject string, batch [][]byte) error {
+	if len(batch) == 1 {
+		return hook.conn.Publish(subject, batch[0])
+	}
+
+	var buf bytes.Buffer
+	for _, b := range batch {
+		// Formatters (e.g. logrus.JSONFormatter) already terminate each
+		// entry with "\n"; trim it so the envelope has exactly one
+		// newline between entries instead of blank lines.
+		buf.Write(bytes.TrimRight(b, "\n"))
+		buf.WriteByte('\n')
+	}
+
+	return hook.conn.Publish(subject+".batch", buf.Bytes())
+}
+
 // Fire will use the connection and try to send the message to the right destination
 func (hook *NatsHook) Fire(entry *logrus.Entry) error {
 	if hook.conn.IsClosed() {
@@ -100,12 +448,28 @@ func (hook *NatsHook) Fire(entry *logrus.Entry) error {
 		entry.Data[k] = generator()
 	}
 
-	bytes, err := hook.formatter.Format(entry)
+	subject := hook.resolveSubject(entry)
+
+	formatted, err := hook.formatter.Format(entry)
 	if err != nil {
 		return err
 	}
 
-	return hook.conn.Publish(hook.subject, bytes)
+	if hook.reliable {
+		return hook.publishReliable(subject, formatted)
+	}
+
+	if hook.async {
+		return hook.enqueue(subject, formatted)
+	}
+
+	if err := hook.conn.Publish(subject, formatted); err != nil {
+		atomic.AddInt64(&hook.errs, 1)
+		return err
+	}
+
+	atomic.AddInt64(&hook.published, 1)
+	return nil
 }
 
 // Levels will describe what levels the NatsHook is associated with
@@ -0,0 +1,160 @@
+// Package natstest provides an in-process stand-in for the NATS connection
+// behind a messaging.NatsHook so callers can assert they emit the right
+// log-to-NATS events without
+// standing up a real nats-server, the way logrus's hooks/test package
+// lets callers assert against a *logrus.Logger.
+package natstest
+
+import (
+	"sync"
+
+	"github.com/nats-io/nats.go"
+	"github.com/netlify/messaging"
+)
+
+// Entry is a single (subject, payload) pair captured by a Hook.
+type Entry struct {
+	Subject string
+	Data    []byte
+}
+
+// Hook is an in-process fake of the NATS connection a messaging.NatsHook
+// publishes through. It records every (subject, payload) pair Fire sends
+// it in a thread-safe slice.
+type Hook struct {
+	mu      sync.Mutex
+	entries []Entry
+
+	js *jetStream
+}
+
+// Publish implements the connection interface messaging.NatsHook depends
+// on by recording subject and data instead of sending them over NATS.
+func (h *Hook) Publish(subject string, data []byte) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	cp := make([]byte, len(data))
+	copy(cp, data)
+	h.entries = append(h.entries, Entry{Subject: subject, Data: cp})
+
+	return nil
+}
+
+// IsClosed always reports false; a Hook never closes.
+func (h *Hook) IsClosed() bool { return false }
+
+// IsConnected always reports true; a Hook never disconnects.
+func (h *Hook) IsConnected() bool { return true }
+
+// JetStream returns an in-process fake JetStreamContext so
+// messaging.NatsHook.EnableReliable, and the reliable-delivery Fire path
+// it switches on, can be exercised against a Hook. JetStreamEntries and
+// FailJetStream inspect and control it.
+func (h *Hook) JetStream(opts ...nats.JSOpt) (nats.JetStreamContext, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.js == nil {
+		h.js = &jetStream{}
+	}
+	return h.js, nil
+}
+
+// JetStreamEntries returns every entry JetStream's fake PublishAsync has
+// recorded, oldest first. It's nil until JetStream (and so EnableReliable)
+// has been called.
+func (h *Hook) JetStreamEntries() []Entry {
+	h.mu.Lock()
+	js := h.js
+	h.mu.Unlock()
+
+	if js == nil {
+		return nil
+	}
+	return js.Published()
+}
+
+// FailJetStream makes every subsequent JetStream publish fail with err
+// instead of succeeding, to exercise the spool-on-publish-error path;
+// err == nil resumes normal publishing. It has no effect until JetStream
+// (and so EnableReliable) has been called.
+func (h *Hook) FailJetStream(err error) {
+	h.mu.Lock()
+	js := h.js
+	h.mu.Unlock()
+
+	if js != nil {
+		js.Fail(err)
+	}
+}
+
+// LastEntry returns the most recently published entry, or nil if nothing
+// has been published yet.
+func (h *Hook) LastEntry() *Entry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.entries) == 0 {
+		return nil
+	}
+
+	entry := h.entries[len(h.entries)-1]
+	return &entry
+}
+
+// AllEntries returns every entry published so far, oldest first.
+func (h *Hook) AllEntries() []Entry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	entries := make([]Entry, len(h.entries))
+	copy(entries, h.entries)
+	return entries
+}
+
+// EntriesOnSubject returns every entry published to subject, oldest first.
+func (h *Hook) EntriesOnSubject(subject string) []Entry {
+	var matched []Entry
+	for _, entry := range h.AllEntries() {
+		if entry.Subject == subject {
+			matched = append(matched, entry)
+		}
+	}
+	return matched
+}
+
+// Reset discards every entry captured so far.
+func (h *Hook) Reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.entries = nil
+}
+
+// DefaultSubject is the subject NewTestHook wires its *messaging.NatsHook
+// to publish on. Tests asserting on subject routing (SubjectFunc,
+// SubjectTemplate, LevelSubjects all override the static subject) can use
+// NewTestHookWithSubject instead.
+const DefaultSubject = "natstest"
+
+// NewTestHook returns a *messaging.NatsHook wired to an in-process Hook
+// instead of a real NATS connection, along with that Hook for making
+// assertions against, mirroring logrus's hooks/test.NewNullLogger.
+func NewTestHook() (*messaging.NatsHook, *Hook) {
+	return NewTestHookWithSubject(DefaultSubject)
+}
+
+// NewTestHookWithSubject is NewTestHook for callers that need the hook's
+// static subject to be something other than DefaultSubject.
+func NewTestHookWithSubject(subject string) (*messaging.NatsHook, *Hook) {
+	fake := &Hook{}
+
+	hook, err := messaging.NewNatsHook(fake, subject)
+	if err != nil {
+		// NewNatsHook never actually fails; a non-nil error here would be
+		// a programmer error in messaging itself.
+		panic(err)
+	}
+
+	return hook, fake
+}
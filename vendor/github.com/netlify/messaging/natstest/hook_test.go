@@ -0,0 +1,100 @@
+package natstest_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/netlify/messaging"
+	"github.com/netlify/messaging/natstest"
+)
+
+func TestAsyncBatchingFramesMultipleEntriesOnBatchSubject(t *testing.T) {
+	hook, fake := natstest.NewTestHookWithSubject("logs.test")
+	hook.EnableAsync(10, 1, 0, 3, "")
+
+	for i := 0; i < 3; i++ {
+		entry := &logrus.Entry{Level: logrus.InfoLevel, Message: "hi", Data: logrus.Fields{}}
+		if err := hook.Fire(entry); err != nil {
+			t.Fatalf("Fire #%d: %v", i, err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := hook.Close(ctx); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	entries := fake.EntriesOnSubject("logs.test.batch")
+	if len(entries) != 1 {
+		t.Fatalf("len(entries on batch subject) = %d, want 1", len(entries))
+	}
+
+	if lines := bytes.Count(entries[0].Data, []byte("\n")); lines != 3 {
+		t.Errorf("batched message has %d newline-delimited entries, want 3", lines)
+	}
+}
+
+func TestOverflowDropNewestAccountsForEveryEntry(t *testing.T) {
+	hook, _ := natstest.NewTestHook()
+	hook.EnableAsync(1, 1, 0, 1, messaging.OverflowDropNewest)
+
+	const total = 50
+	for i := 0; i < total; i++ {
+		entry := &logrus.Entry{Level: logrus.InfoLevel, Message: "hi", Data: logrus.Fields{}}
+		if err := hook.Fire(entry); err != nil {
+			t.Fatalf("Fire #%d: %v", i, err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := hook.Close(ctx); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	if got := hook.Published() + hook.Dropped(); got != total {
+		t.Errorf("Published(%d) + Dropped(%d) = %d, want %d", hook.Published(), hook.Dropped(), got, total)
+	}
+}
+
+func TestReliableSpoolsOnPublishErrorAndPublishesOnceItClears(t *testing.T) {
+	hook, fake := natstest.NewTestHookWithSubject("logs.test")
+
+	if err := hook.EnableReliable(messaging.ReliableConf{
+		Stream:   "LOGS",
+		SpoolDir: t.TempDir(),
+	}); err != nil {
+		t.Fatalf("EnableReliable: %v", err)
+	}
+
+	fake.FailJetStream(errors.New("jetstream unavailable"))
+
+	entry := &logrus.Entry{Level: logrus.InfoLevel, Message: "hi", Data: logrus.Fields{}}
+	if err := hook.Fire(entry); err != nil {
+		t.Fatalf("Fire: %v", err)
+	}
+
+	if got := len(fake.JetStreamEntries()); got != 0 {
+		t.Fatalf("JetStreamEntries() = %d while JetStream is failing, want 0", got)
+	}
+
+	fake.FailJetStream(nil)
+	if err := hook.Fire(entry); err != nil {
+		t.Fatalf("Fire: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := hook.Close(ctx); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	if got := len(fake.JetStreamEntries()); got != 1 {
+		t.Errorf("JetStreamEntries() = %d once JetStream recovers, want 1 (the entry spooled while it was failing stays spooled until a reconnect replay, not resent on the next successful Fire)", got)
+	}
+}
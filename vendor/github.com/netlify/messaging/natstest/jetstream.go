@@ -0,0 +1,68 @@
+package natstest
+
+import (
+	"sync"
+
+	"github.com/nats-io/nats.go"
+)
+
+// jetStream is a minimal fake of nats.JetStreamContext. It implements only
+// PublishAsync, the one JetStream method messaging's reliable-delivery
+// mode (EnableReliable/publishReliable/replaySpool) actually calls;
+// embedding a nil nats.JetStreamContext lets it satisfy the rest of the
+// (much larger) interface without implementing methods nothing exercises.
+type jetStream struct {
+	nats.JetStreamContext
+
+	mu        sync.Mutex
+	published []Entry
+	fail      error
+}
+
+// PublishAsync records (subj, data) and resolves immediately, unless Fail
+// has set an error to return instead.
+func (j *jetStream) PublishAsync(subj string, data []byte, opts ...nats.PubOpt) (nats.PubAckFuture, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.fail != nil {
+		return nil, j.fail
+	}
+
+	cp := make([]byte, len(data))
+	copy(cp, data)
+	j.published = append(j.published, Entry{Subject: subj, Data: cp})
+
+	return resolvedAck{}, nil
+}
+
+// Published returns every entry PublishAsync has recorded, oldest first.
+func (j *jetStream) Published() []Entry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	entries := make([]Entry, len(j.published))
+	copy(entries, j.published)
+	return entries
+}
+
+// Fail makes every subsequent PublishAsync call return err instead of
+// recording an entry; err == nil resumes normal publishing.
+func (j *jetStream) Fail(err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.fail = err
+}
+
+// resolvedAck is a nats.PubAckFuture that's already succeeded.
+type resolvedAck struct{}
+
+func (resolvedAck) Ok() <-chan *nats.PubAck {
+	ch := make(chan *nats.PubAck, 1)
+	ch <- &nats.PubAck{}
+	return ch
+}
+
+func (resolvedAck) Err() <-chan error { return nil }
+
+func (resolvedAck) Msg() *nats.Msg { return nil }
@@ -0,0 +1,171 @@
+package messaging
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// errReplayDisconnected stops replaySpool's Drain early when the
+// connection drops mid-replay; it's not reported to ErrHandler since it
+// isn't a publish failure, just a reason to stop and try again on the
+// next reconnect.
+var errReplayDisconnected = errors.New("messaging: connection lost during spool replay")
+
+// ReliableConf configures NatsHook's at-least-once delivery mode: publish
+// through JetStream with async acks, and spool to disk whenever the
+// connection is down or too many acks are outstanding.
+type ReliableConf struct {
+	// Stream is the JetStream stream entries are published into.
+	Stream string
+	// MaxPending bounds the number of outstanding (un-acked) async
+	// publishes before Fire spools instead of publishing. <= 0 means
+	// unlimited (JetStream's own default applies).
+	MaxPending int
+	// AckWait is how long JetStream waits for an ack before redelivering.
+	AckWait time.Duration
+	// SpoolDir holds the on-disk FIFO used while disconnected or backed up.
+	SpoolDir string
+	// SpoolMaxBytes bounds the spool file; the oldest entries are dropped
+	// once it's exceeded.
+	SpoolMaxBytes int64
+	// SpoolSync fsyncs every spooled record. Off by default for throughput.
+	SpoolSync bool
+	// ErrHandler, if set, receives errors from JetStream's async publish
+	// acks that couldn't be resolved by spooling.
+	ErrHandler func(error)
+}
+
+// EnableReliable switches the hook into JetStream-backed, at-least-once
+// delivery: while the connection is up and acks aren't backed up beyond
+// conf.MaxPending, entries publish via js.PublishAsync; otherwise they're
+// appended to an on-disk spool and replayed, oldest first, once the
+// connection's reconnect handler fires.
+func (hook *NatsHook) EnableReliable(conf ReliableConf) error {
+	var jsOpts []nats.JSOpt
+	if conf.MaxPending > 0 {
+		jsOpts = append(jsOpts, nats.PublishAsyncMaxPending(conf.MaxPending))
+	}
+
+	js, err := hook.conn.JetStream(jsOpts...)
+	if err != nil {
+		return err
+	}
+
+	spool, err := newDiskSpool(conf.SpoolDir, conf.SpoolMaxBytes, conf.SpoolSync)
+	if err != nil {
+		return err
+	}
+
+	hook.reliable = true
+	hook.js = js
+	hook.stream = conf.Stream
+	hook.maxPending = conf.MaxPending
+	hook.ackWait = conf.AckWait
+	hook.spool = spool
+	hook.errHandler = conf.ErrHandler
+
+	// Reconnect handling is only meaningful for a real NATS connection;
+	// fakes used in tests (see messaging/natstest) don't flap.
+	if nc, ok := hook.conn.(*nats.Conn); ok {
+		prevReconnect := nc.Opts.ReconnectedCB
+		nc.Opts.ReconnectedCB = func(c *nats.Conn) {
+			if prevReconnect != nil {
+				prevReconnect(c)
+			}
+			hook.replaySpool()
+		}
+	}
+
+	return nil
+}
+
+// publishReliable is the Fire path used once EnableReliable has run: it
+// spools instead of publishing while disconnected or while too many acks
+// are outstanding, and publishes via JetStream otherwise.
+func (hook *NatsHook) publishReliable(subject string, formatted []byte) error {
+	backedUp := hook.maxPending > 0 && atomic.LoadInt64(&hook.pendingAcks) >= int64(hook.maxPending)
+
+	if !hook.conn.IsConnected() || backedUp {
+		if err := hook.spool.Append(subject, formatted); err != nil {
+			atomic.AddInt64(&hook.errs, 1)
+			return err
+		}
+		return nil
+	}
+
+	atomic.AddInt64(&hook.pendingAcks, 1)
+
+	opts := []nats.PubOpt{nats.ExpectStream(hook.stream)}
+	if hook.ackWait > 0 {
+		opts = append(opts, nats.AckWait(hook.ackWait))
+	}
+
+	future, err := hook.js.PublishAsync(subject, formatted, opts...)
+	if err != nil {
+		atomic.AddInt64(&hook.pendingAcks, -1)
+		if spoolErr := hook.spool.Append(subject, formatted); spoolErr != nil {
+			atomic.AddInt64(&hook.errs, 1)
+			return spoolErr
+		}
+		return nil
+	}
+
+	go hook.awaitAck(future, subject, formatted)
+
+	return nil
+}
+
+// awaitAck waits for a single JetStream publish ack, spooling the entry
+// for replay if the ack comes back an error and reporting the failure to
+// hook.errHandler. It has no timeout of its own: if neither an ack nor an
+// error arrives, it keeps waiting.
+func (hook *NatsHook) awaitAck(future nats.PubAckFuture, subject string, formatted []byte) {
+	defer atomic.AddInt64(&hook.pendingAcks, -1)
+
+	select {
+	case <-future.Ok():
+		atomic.AddInt64(&hook.published, 1)
+	case err := <-future.Err():
+		atomic.AddInt64(&hook.errs, 1)
+		if hook.errHandler != nil {
+			hook.errHandler(err)
+		}
+		if spoolErr := hook.spool.Append(subject, formatted); spoolErr != nil && hook.errHandler != nil {
+			hook.errHandler(spoolErr)
+		}
+	}
+}
+
+// replaySpool flushes every record currently spooled back through
+// JetStream, in FIFO order, clearing the spool once they've all been
+// handed off successfully. It drives the spool's Drain, which holds the
+// spool lock for the whole read-replay-clear cycle: a record spooled by
+// publishReliable while this replay is still running simply isn't part
+// of the Drain and survives the final clear, instead of being silently
+// dropped.
+func (hook *NatsHook) replaySpool() {
+	err := hook.spool.Drain(func(record spoolRecord) error {
+		if !hook.conn.IsConnected() {
+			return errReplayDisconnected
+		}
+
+		future, err := hook.js.PublishAsync(record.Subject, record.Data, nats.ExpectStream(hook.stream))
+		if err != nil {
+			return err
+		}
+
+		select {
+		case <-future.Ok():
+			return nil
+		case err := <-future.Err():
+			return err
+		}
+	})
+
+	if err != nil && err != errReplayDisconnected && hook.errHandler != nil {
+		hook.errHandler(err)
+	}
+}
@@ -0,0 +1,309 @@
+package messaging
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// spoolRecord is one formatted entry held in a diskSpool, along with the
+// subject it resolved to at Fire time so replay publishes it to the same
+// place it would have gone had the connection been up.
+type spoolRecord struct {
+	Subject string
+	Data    []byte
+}
+
+// diskSpool is a bounded, append-only FIFO of length-prefixed records used
+// to hold formatted log entries while a NatsHook can't reach NATS. Each
+// top-level record is a 4-byte big-endian length header followed by an
+// encoded spoolRecord (itself a length-prefixed subject and payload).
+type diskSpool struct {
+	mu       sync.Mutex
+	path     string
+	maxBytes int64
+	fsync    bool
+	size     int64
+}
+
+func newDiskSpool(dir string, maxBytes int64, fsync bool) (*diskSpool, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	s := &diskSpool{
+		path:     filepath.Join(dir, "nats-hook.spool"),
+		maxBytes: maxBytes,
+		fsync:    fsync,
+	}
+
+	if fi, err := os.Stat(s.path); err == nil {
+		s.size = fi.Size()
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Append writes (subject, b) to the end of the spool, compacting the
+// oldest records out if that would push the spool past maxBytes.
+func (s *diskSpool) Append(subject string, b []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	encoded := encodeSpoolRecord(subject, b)
+	recordSize := int64(4 + len(encoded))
+
+	if s.maxBytes > 0 && s.size+recordSize > s.maxBytes {
+		if err := s.compact(recordSize); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := writeRecord(f, encoded); err != nil {
+		return err
+	}
+
+	if s.fsync {
+		if err := f.Sync(); err != nil {
+			return err
+		}
+	}
+
+	s.size += recordSize
+	return nil
+}
+
+// compact drops the oldest spooled records until there's room for an
+// incoming record of size needed, then rewrites the spool file.
+func (s *diskSpool) compact(needed int64) error {
+	records, err := s.readAll()
+	if err != nil {
+		return err
+	}
+
+	total := needed
+	for _, r := range records {
+		total += int64(4 + len(r))
+	}
+
+	for len(records) > 0 && total > s.maxBytes {
+		total -= int64(4 + len(records[0]))
+		records = records[1:]
+	}
+
+	return s.writeAll(records)
+}
+
+// Replay returns every record currently in the spool, oldest first.
+func (s *diskSpool) Replay() ([]spoolRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	encoded, err := s.readAll()
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]spoolRecord, len(encoded))
+	for i, e := range encoded {
+		record, err := decodeSpoolRecord(e)
+		if err != nil {
+			return nil, err
+		}
+		records[i] = record
+	}
+
+	return records, nil
+}
+
+// Clear empties the spool, typically called once Replay's records have
+// all been published successfully.
+func (s *diskSpool) Clear() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.size = 0
+	err := os.Remove(s.path)
+	if err != nil && os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Drain hands every record currently in the spool to fn, oldest first,
+// and empties the spool only if every call succeeds. Unlike a separate
+// Replay+Clear pair, it holds the spool lock for the entire read-replay-
+// clear cycle, so a record Appended while fn is still running (e.g. by
+// Fire on another goroutine) is never part of this Drain and isn't lost
+// when the spool is cleared — it simply waits for Append to get the lock
+// back and is picked up by the next Drain. If fn returns an error partway
+// through, the spool is left untouched; already-drained records are
+// replayed again next time, which is fine for at-least-once delivery.
+func (s *diskSpool) Drain(fn func(spoolRecord) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	encoded, err := s.readAll()
+	if err != nil {
+		return err
+	}
+	if len(encoded) == 0 {
+		return nil
+	}
+
+	records := make([]spoolRecord, len(encoded))
+	for i, e := range encoded {
+		record, err := decodeSpoolRecord(e)
+		if err != nil {
+			return err
+		}
+		records[i] = record
+	}
+
+	for _, record := range records {
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+
+	s.size = 0
+	err = os.Remove(s.path)
+	if err != nil && os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *diskSpool) readAll() ([][]byte, error) {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records [][]byte
+	for {
+		record, err := readRecord(f)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+func (s *diskSpool) writeAll(records [][]byte) error {
+	tmp := s.path + ".tmp"
+
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+
+	var size int64
+	for _, r := range records {
+		if err := writeRecord(f, r); err != nil {
+			f.Close()
+			return err
+		}
+		size += int64(4 + len(r))
+	}
+
+	if s.fsync {
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return err
+		}
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+
+	s.size = size
+	return nil
+}
+
+// encodeSpoolRecord packs subject and data into a single blob as a
+// 4-byte subject length, the subject, a 4-byte data length, then the data.
+func encodeSpoolRecord(subject string, data []byte) []byte {
+	buf := make([]byte, 0, 4+len(subject)+4+len(data))
+
+	var lenBuf [4]byte
+	binary.BigEndian.PutUint32(lenBuf[:], uint32(len(subject)))
+	buf = append(buf, lenBuf[:]...)
+	buf = append(buf, subject...)
+
+	binary.BigEndian.PutUint32(lenBuf[:], uint32(len(data)))
+	buf = append(buf, lenBuf[:]...)
+	buf = append(buf, data...)
+
+	return buf
+}
+
+// decodeSpoolRecord reverses encodeSpoolRecord.
+func decodeSpoolRecord(b []byte) (spoolRecord, error) {
+	if len(b) < 4 {
+		return spoolRecord{}, io.ErrUnexpectedEOF
+	}
+	subjectLen := binary.BigEndian.Uint32(b[:4])
+	b = b[4:]
+
+	if uint32(len(b)) < subjectLen+4 {
+		return spoolRecord{}, io.ErrUnexpectedEOF
+	}
+	subject := string(b[:subjectLen])
+	b = b[subjectLen:]
+
+	dataLen := binary.BigEndian.Uint32(b[:4])
+	b = b[4:]
+
+	if uint32(len(b)) < dataLen {
+		return spoolRecord{}, io.ErrUnexpectedEOF
+	}
+
+	return spoolRecord{Subject: subject, Data: b[:dataLen]}, nil
+}
+
+func writeRecord(w io.Writer, b []byte) error {
+	var header [4]byte
+	binary.BigEndian.PutUint32(header[:], uint32(len(b)))
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+func readRecord(r io.Reader) ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, binary.BigEndian.Uint32(header[:]))
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
@@ -0,0 +1,130 @@
+package messaging
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestDiskSpoolReplayPreservesSubjectAndFIFOOrder(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := newDiskSpool(filepath.Join(dir, "spool"), 0, false)
+	if err != nil {
+		t.Fatalf("newDiskSpool: %v", err)
+	}
+
+	if err := s.Append("logs.info", []byte("first")); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	if err := s.Append("logs.err", []byte("second")); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+
+	records, err := s.Replay()
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("len(records) = %d, want 2", len(records))
+	}
+
+	if records[0].Subject != "logs.info" || string(records[0].Data) != "first" {
+		t.Errorf("records[0] = %+v, want {logs.info first}", records[0])
+	}
+	if records[1].Subject != "logs.err" || string(records[1].Data) != "second" {
+		t.Errorf("records[1] = %+v, want {logs.err second}", records[1])
+	}
+
+	if err := s.Clear(); err != nil {
+		t.Fatalf("Clear: %v", err)
+	}
+
+	records, err = s.Replay()
+	if err != nil {
+		t.Fatalf("Replay after Clear: %v", err)
+	}
+	if len(records) != 0 {
+		t.Errorf("len(records) after Clear = %d, want 0", len(records))
+	}
+}
+
+func TestDiskSpoolCompactDropsOldestUnderMaxBytes(t *testing.T) {
+	dir := t.TempDir()
+
+	// Each "subj"+10-byte-payload record encodes to 22 bytes (+4 byte
+	// outer length prefix = 26), so a 40-byte budget holds exactly one:
+	// every Append should compact away whatever came before it.
+	s, err := newDiskSpool(filepath.Join(dir, "spool"), 40, false)
+	if err != nil {
+		t.Fatalf("newDiskSpool: %v", err)
+	}
+
+	for i, payload := range []string{"aaaaaaaaaa", "bbbbbbbbbb", "cccccccccc"} {
+		if err := s.Append("subj", []byte(payload)); err != nil {
+			t.Fatalf("Append #%d: %v", i, err)
+		}
+	}
+
+	records, err := s.Replay()
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+
+	if len(records) != 1 {
+		t.Fatalf("len(records) = %d, want 1 (older records should have been compacted away)", len(records))
+	}
+	if string(records[0].Data) != "cccccccccc" {
+		t.Errorf("surviving record = %q, want the last appended payload", records[0].Data)
+	}
+}
+
+func TestDiskSpoolDrainDoesNotDropRecordAppendedDuringReplay(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := newDiskSpool(filepath.Join(dir, "spool"), 0, false)
+	if err != nil {
+		t.Fatalf("newDiskSpool: %v", err)
+	}
+
+	if err := s.Append("logs.info", []byte("queued-before-replay")); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	err = s.Drain(func(record spoolRecord) error {
+		// Simulate Fire spooling a new entry on another goroutine while
+		// this replay is still in flight (e.g. replaySpool running on
+		// the NATS client's reconnect-callback goroutine). Append blocks
+		// on s.mu until Drain releases it, so with Drain holding the lock
+		// for the whole cycle this only completes after Drain's final
+		// clear has run. If Drain held the lock for only part of the
+		// cycle, the record could land before that clear and be wiped —
+		// the bug this test guards against.
+		go func() {
+			defer wg.Done()
+			if err := s.Append("logs.err", []byte("appended-during-replay")); err != nil {
+				t.Errorf("concurrent Append: %v", err)
+			}
+		}()
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Drain: %v", err)
+	}
+
+	wg.Wait()
+
+	records, err := s.Replay()
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("len(records) after Drain = %d, want 1 (the concurrently appended record must survive Drain's Clear)", len(records))
+	}
+	if records[0].Subject != "logs.err" || string(records[0].Data) != "appended-during-replay" {
+		t.Errorf("records[0] = %+v, want {logs.err appended-during-replay}", records[0])
+	}
+}
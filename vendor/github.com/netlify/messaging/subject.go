@@ -0,0 +1,105 @@
+package messaging
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// subjectSanitizer replaces NATS subject-token separators and whitespace
+// with "_" so interpolated field values can't accidentally create or
+// collapse subject tokens.
+var subjectSanitizer = strings.NewReplacer(
+	".", "_",
+	"*", "_",
+	">", "_",
+	" ", "_",
+	"\t", "_",
+	"\n", "_",
+)
+
+// subjectTemplateData is what a SubjectTemplate renders against:
+// {{.Level}} and {{.Fields.<key>}}. Fields is map[string]interface{},
+// not map[string]string: text/template represents a missing key on a
+// concrete-valued map as an invalid reflect.Value, which the "default"
+// func below can't accept as a string argument, but happily accepts as
+// a nil interface{}.
+type subjectTemplateData struct {
+	Level  string
+	Fields map[string]interface{}
+}
+
+var subjectTemplateFuncs = template.FuncMap{
+	"default": func(def, value interface{}) string {
+		if value == nil || fmt.Sprint(value) == "" {
+			return fmt.Sprint(def)
+		}
+		return fmt.Sprint(value)
+	},
+}
+
+func newSubjectTemplateData(entry *logrus.Entry) subjectTemplateData {
+	fields := make(map[string]interface{}, len(entry.Data))
+	for k, v := range entry.Data {
+		fields[k] = subjectSanitizer.Replace(fmt.Sprint(v))
+	}
+
+	return subjectTemplateData{
+		Level:  entry.Level.String(),
+		Fields: fields,
+	}
+}
+
+// SetSubjectTemplate routes entries to a subject rendered from tmpl, a
+// text/template string evaluated per Fire against {{.Level}} and
+// {{.Fields.<key>}}, e.g. `logs.{{.Level}}.{{.Fields.service}}`. It's
+// parsed (and any syntax error returned) immediately so a bad template
+// fails at setup instead of on first log emission.
+func (hook *NatsHook) SetSubjectTemplate(tmpl string) error {
+	t, err := template.New("subject").Funcs(subjectTemplateFuncs).Parse(tmpl)
+	if err != nil {
+		return err
+	}
+
+	hook.subjectTemplate = t
+	return nil
+}
+
+// SetSubjectFunc routes every entry through fn to compute its publish
+// subject. It takes priority over SubjectTemplate and LevelSubjects.
+func (hook *NatsHook) SetSubjectFunc(fn func(*logrus.Entry) string) *NatsHook {
+	hook.subjectFunc = fn
+	return hook
+}
+
+// SetLevelSubjects routes entries to a fixed subject per logrus.Level,
+// falling back to the hook's static subject for levels with no entry.
+func (hook *NatsHook) SetLevelSubjects(subjects map[logrus.Level]string) *NatsHook {
+	hook.levelSubjects = subjects
+	return hook
+}
+
+// resolveSubject picks the subject to publish entry on: SubjectFunc takes
+// priority, then SubjectTemplate, then LevelSubjects, falling back to the
+// hook's static subject.
+func (hook *NatsHook) resolveSubject(entry *logrus.Entry) string {
+	if hook.subjectFunc != nil {
+		return hook.subjectFunc(entry)
+	}
+
+	if hook.subjectTemplate != nil {
+		var buf bytes.Buffer
+		if err := hook.subjectTemplate.Execute(&buf, newSubjectTemplateData(entry)); err == nil {
+			return buf.String()
+		}
+	}
+
+	if subject, ok := hook.levelSubjects[entry.Level]; ok {
+		return subject
+	}
+
+	return hook.subject
+}
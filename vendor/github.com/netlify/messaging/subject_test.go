@@ -0,0 +1,54 @@
+package messaging
+
+import (
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+)
+
+func TestSetSubjectTemplateRejectsInvalidSyntax(t *testing.T) {
+	hook := &NatsHook{subject: "logs.default"}
+
+	if err := hook.SetSubjectTemplate("logs.{{.Level"); err == nil {
+		t.Fatal("expected an error for an unterminated template action, got nil")
+	}
+}
+
+func TestResolveSubjectTemplateSanitizesFields(t *testing.T) {
+	hook := &NatsHook{subject: "logs.default"}
+
+	if err := hook.SetSubjectTemplate(`logs.{{.Level}}.{{.Fields.service}}.{{.Fields.tenant | default "none"}}`); err != nil {
+		t.Fatalf("SetSubjectTemplate returned error: %v", err)
+	}
+
+	entry := &logrus.Entry{
+		Level: logrus.ErrorLevel,
+		Data:  logrus.Fields{"service": "billing.api"},
+	}
+
+	got := hook.resolveSubject(entry)
+	want := "logs.error.billing_api.none"
+	if got != want {
+		t.Errorf("resolveSubject = %q, want %q", got, want)
+	}
+}
+
+func TestResolveSubjectPriority(t *testing.T) {
+	hook := &NatsHook{subject: "logs.default"}
+	hook.SetLevelSubjects(map[logrus.Level]string{logrus.ErrorLevel: "logs.err"})
+
+	errEntry := &logrus.Entry{Level: logrus.ErrorLevel, Data: logrus.Fields{}}
+	if got := hook.resolveSubject(errEntry); got != "logs.err" {
+		t.Errorf("resolveSubject(error) = %q, want logs.err (LevelSubjects fallback)", got)
+	}
+
+	infoEntry := &logrus.Entry{Level: logrus.InfoLevel, Data: logrus.Fields{}}
+	if got := hook.resolveSubject(infoEntry); got != "logs.default" {
+		t.Errorf("resolveSubject(info) = %q, want the static subject", got)
+	}
+
+	hook.SetSubjectFunc(func(*logrus.Entry) string { return "logs.func" })
+	if got := hook.resolveSubject(errEntry); got != "logs.func" {
+		t.Errorf("resolveSubject with SubjectFunc set = %q, want logs.func (SubjectFunc takes priority)", got)
+	}
+}